@@ -0,0 +1,73 @@
+package bosh
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Client is the subset of BoshProxy's behavior Handler depends on, split
+// out so tests can substitute a fake director instead of shelling out to
+// the real bosh CLI.
+type Client interface {
+	UploadStemcell(stemcell string) error
+	UploadRelease(release string) error
+	Deploy(manifestPath string) (string, error)
+	DeleteDeployment(name string) (string, error)
+	Status(taskId string) (string, error)
+}
+
+// BoshProxy wraps the `bosh` CLI to upload releases/stemcells, deploy
+// manifests and poll task status against a single BOSH director.
+type BoshProxy struct {
+	target   string
+	user     string
+	password string
+	// uploadMu serializes UploadStemcell/UploadRelease: the bosh CLI
+	// doesn't tolerate concurrent uploads of the same artifact.
+	uploadMu sync.Mutex
+}
+
+func NewBoshProxy(target, user, password string) (*BoshProxy, string, error) {
+	b := &BoshProxy{target: target, user: user, password: password}
+	out, err := b.run("environment", "--json")
+	if err != nil {
+		return nil, "", err
+	}
+	return b, strings.TrimSpace(out), nil
+}
+
+func (b *BoshProxy) UploadStemcell(stemcell string) error {
+	b.uploadMu.Lock()
+	defer b.uploadMu.Unlock()
+	_, err := b.run("upload-stemcell", stemcell)
+	return err
+}
+
+func (b *BoshProxy) UploadRelease(release string) error {
+	b.uploadMu.Lock()
+	defer b.uploadMu.Unlock()
+	_, err := b.run("upload-release", release)
+	return err
+}
+
+func (b *BoshProxy) Deploy(manifestPath string) (string, error) {
+	return b.run("deploy", manifestPath)
+}
+
+func (b *BoshProxy) DeleteDeployment(name string) (string, error) {
+	return b.run("delete-deployment", "-d", name)
+}
+
+func (b *BoshProxy) Status(taskId string) (string, error) {
+	return b.run("task", taskId)
+}
+
+func (b *BoshProxy) run(args ...string) (string, error) {
+	cmd := exec.Command("bosh", append([]string{"-e", b.target, "-u", b.user, "-p", b.password}, args...)...)
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	err := cmd.Run()
+	return out.String(), err
+}
@@ -0,0 +1,251 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/rs/zerolog"
+
+	"github.com/s-matyukevich/bosh-broker/source/store"
+)
+
+// minAsyncBindingAPIVersion is the first OSB API minor version (within
+// major version 2) that defines binding-level async, GetBinding and
+// GetLastBindingOperation.
+const minAsyncBindingAPIVersion = 14
+
+// supportsAsyncBinding reports whether apiVersion (an X-Broker-API-Version
+// header value, e.g. "2.14") is new enough for binding-level async. An
+// unparsable or pre-2.14 version returns false, which Bind/Unbind treat as
+// "fall back to synchronous" rather than a hard error, matching how the
+// rest of the OSB surface degrades for older platforms.
+func supportsAsyncBinding(apiVersion string) bool {
+	var major, minor int
+	if n, err := fmt.Sscanf(apiVersion, "%d.%d", &major, &minor); err != nil || n != 2 {
+		return false
+	}
+	return major == 2 && minor >= minAsyncBindingAPIVersion
+}
+
+// BindingState tracks the lifecycle of an asynchronous bind or unbind
+// script, mirroring the OSB API's notion of a last operation.
+type BindingState string
+
+const (
+	BindingInProgress BindingState = "in progress"
+	BindingSucceeded  BindingState = "succeeded"
+	BindingFailed     BindingState = "failed"
+)
+
+// bindingOperationTTL bounds how long a terminal BindingOperation is kept
+// in Handler.bindings after it completes. GetLastBindingOperation used to
+// evict an operation the moment it reported a terminal state, but the OSB
+// API allows (and CF's own retry logic relies on) polling the same
+// operation again after it's already terminal - that eviction turned a
+// dropped response into a hard "no operation in progress" error on retry
+// instead of idempotently repeating the same succeeded/failed result. The
+// sweep in Handler.sweepBindingOps reclaims the map entry once TTL has
+// safely passed instead.
+const bindingOperationTTL = time.Hour
+
+// BindingOperation is the in-flight/completed state of a single async
+// bind or unbind, keyed by bindingID in Handler.bindings. It's shared
+// between the goroutine running the bind/unbind script and whatever HTTP
+// request handler happens to poll GetLastBindingOperation/GetBinding while
+// that script is still running, so all access goes through mu.
+type BindingOperation struct {
+	mu          sync.Mutex
+	state       BindingState
+	credentials map[string]interface{}
+	err         string
+	// completedAt is the zero time while state is BindingInProgress, and
+	// set once it reaches a terminal state, so sweepBindingOps knows how
+	// long an entry has been sitting there before reclaiming it.
+	completedAt time.Time
+}
+
+func (op *BindingOperation) snapshot() (BindingState, map[string]interface{}, string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.state, op.credentials, op.err
+}
+
+func (op *BindingOperation) fail(err error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.state = BindingFailed
+	op.err = err.Error()
+	op.completedAt = time.Now()
+}
+
+func (op *BindingOperation) succeed(credentials map[string]interface{}) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.state = BindingSucceeded
+	op.credentials = credentials
+	op.completedAt = time.Now()
+}
+
+// staleSince reports whether op reached a terminal state more than ttl
+// ago, i.e. it's safe for sweepBindingOps to reclaim.
+func (op *BindingOperation) staleSince(ttl time.Duration) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.state != BindingInProgress && time.Since(op.completedAt) > ttl
+}
+
+// GetBinding returns the credentials produced by a previously succeeded
+// async Bind, as required by GET /v2/service_instances/:id/service_bindings/:id.
+func (h Handler) GetBinding(instanceID, bindingID string) (brokerapi.GetBindingSpec, error) {
+	if op, ok := h.getBindingOp(bindingID); ok {
+		state, credentials, _ := op.snapshot()
+		if state != BindingSucceeded {
+			return brokerapi.GetBindingSpec{}, fmt.Errorf("binding %s has not succeeded yet", bindingID)
+		}
+		return brokerapi.GetBindingSpec{Credentials: credentials}, nil
+	}
+	rec, err := h.store.GetBinding(bindingID)
+	if err != nil {
+		return brokerapi.GetBindingSpec{}, err
+	}
+	return brokerapi.GetBindingSpec{Credentials: rec.Credentials}, nil
+}
+
+// GetLastBindingOperation reports the current state of an async bind or
+// unbind previously started by Bind/Unbind.
+func (h Handler) GetLastBindingOperation(instanceID, bindingID string, _ brokerapi.PollDetails) (brokerapi.LastOperation, error) {
+	op, ok := h.getBindingOp(bindingID)
+	if !ok {
+		return brokerapi.LastOperation{}, fmt.Errorf("no operation in progress for binding %s", bindingID)
+	}
+	state, _, opErr := op.snapshot()
+	lo := brokerapi.LastOperation{}
+	switch state {
+	case BindingInProgress:
+		lo.State = brokerapi.InProgress
+		return lo, nil
+	case BindingSucceeded:
+		lo.State = brokerapi.Succeeded
+	case BindingFailed:
+		lo.State = brokerapi.Failed
+		lo.Description = opErr
+	default:
+		return lo, fmt.Errorf("unknown binding operation state: %s", state)
+	}
+	// Unlike the old behavior, a terminal op is left in h.bindings rather
+	// than deleted here: a platform is allowed to poll the same operation
+	// again after it's terminal (e.g. retrying a dropped response), and
+	// that poll must keep repeating the same succeeded/failed result, not
+	// start erroring with "no operation in progress". sweepBindingOps
+	// reclaims the entry once bindingOperationTTL has passed instead.
+	return lo, nil
+}
+
+// relayStderr logs each line a running script writes to stderr at WARN
+// level instead of letting it disappear, as it did before runBindAsync and
+// runUnbindAsync captured this pipe.
+func relayStderr(stderr io.Reader, logger zerolog.Logger, path string) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		logger.Warn().Str("cmd", path).Msg(scanner.Text())
+	}
+}
+
+// runBindAsync executes the rendered bind script in the background,
+// killing it if it runs past timeout, and records the result in op.
+func (h Handler) runBindAsync(instanceID, bindingID, bindPath string, timeout time.Duration, op *BindingOperation) {
+	cmd := exec.Command(bindPath)
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		op.fail(err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		op.fail(err)
+		return
+	}
+	go relayStderr(stderr, h.logger, cmd.Path)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timedOut = time.After(timeout)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			op.fail(err)
+			return
+		}
+		creds := make(map[string]interface{}, 0)
+		if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+			op.fail(err)
+			return
+		}
+		creds, err = h.storeCredentials(instanceID, bindingID, creds)
+		if err != nil {
+			op.fail(err)
+			return
+		}
+		if err := h.store.PutBinding(bindingID, &store.Binding{InstanceID: instanceID, Credentials: creds}); err != nil {
+			op.fail(err)
+			return
+		}
+		op.succeed(creds)
+	case <-timedOut:
+		cmd.Process.Kill()
+		op.fail(fmt.Errorf("bind script did not finish within %s", timeout))
+	}
+}
+
+// runUnbindAsync is runBindAsync's counterpart for unbind scripts, which
+// produce no credentials on success.
+func (h Handler) runUnbindAsync(instanceID, bindingID, unbindPath string, timeout time.Duration, op *BindingOperation) {
+	cmd := exec.Command(unbindPath)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		op.fail(err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		op.fail(err)
+		return
+	}
+	go relayStderr(stderr, h.logger, cmd.Path)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timedOut = time.After(timeout)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			op.fail(err)
+			return
+		}
+		if err := h.deleteBindingCredentials(instanceID, bindingID); err != nil {
+			op.fail(err)
+			return
+		}
+		op.succeed(nil)
+	case <-timedOut:
+		cmd.Process.Kill()
+		op.fail(fmt.Errorf("unbind script did not finish within %s", timeout))
+	}
+}
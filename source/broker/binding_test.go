@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/rs/zerolog"
+
+	"github.com/s-matyukevich/bosh-broker/source/config"
+	"github.com/s-matyukevich/bosh-broker/source/store"
+)
+
+// TestAsyncBindRaceWithConcurrentPolling starts an async bind and hammers
+// GetLastBindingOperation/GetBinding concurrently while the bind script is
+// still running, under the race detector. BindingOperation used to be
+// mutated by runBindAsync with no synchronization while these same fields
+// were read from a separate, concurrently-polling goroutine - exactly what
+// CF does while an async bind is in flight.
+func TestAsyncBindRaceWithConcurrentPolling(t *testing.T) {
+	dir := t.TempDir()
+
+	st, err := store.NewBoltStore(filepath.Join(dir, "broker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	bindPath := filepath.Join(dir, "bind.sh")
+	script := "#!/bin/sh\nsleep 0.2\necho '{\"user\":\"u\"}'\n"
+	if err := ioutil.WriteFile(bindPath, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler{
+		config:     &config.Config{},
+		store:      st,
+		bindings:   make(map[string]*BindingOperation),
+		bindingsMu: &sync.RWMutex{},
+		logger:     zerolog.Nop(),
+	}
+
+	op := &BindingOperation{state: BindingInProgress}
+	h.setBindingOp("binding-1", op)
+
+	bindDone := make(chan struct{})
+	go func() {
+		defer close(bindDone)
+		h.runBindAsync("instance-1", "binding-1", bindPath, time.Second, op)
+	}()
+
+	stop := make(chan struct{})
+	var pollers sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.GetLastBindingOperation("instance-1", "binding-1", brokerapi.PollDetails{})
+					h.GetBinding("instance-1", "binding-1")
+				}
+			}
+		}()
+	}
+
+	<-bindDone
+	close(stop)
+	pollers.Wait()
+
+	if _, err := st.GetBinding("binding-1"); err != nil {
+		t.Fatalf("expected bind script's credentials to be persisted, got: %s", err)
+	}
+}
+
+func TestSupportsAsyncBinding(t *testing.T) {
+	cases := map[string]bool{
+		"2.14": true,
+		"2.15": true,
+		"2.13": false,
+		"2.0":  false,
+		"3.14": false,
+		"":     false,
+		"junk": false,
+		"2":    false,
+	}
+	for apiVersion, want := range cases {
+		if got := supportsAsyncBinding(apiVersion); got != want {
+			t.Errorf("supportsAsyncBinding(%q) = %v, want %v", apiVersion, got, want)
+		}
+	}
+}
+
+// TestGetLastBindingOperationIsIdempotentAfterTerminalState polls a
+// completed operation twice, as a platform retrying a dropped response
+// would. GetLastBindingOperation used to evict the operation the first
+// time it reported a terminal state, turning the retry into a hard "no
+// operation in progress" error instead of repeating the same result.
+func TestGetLastBindingOperationIsIdempotentAfterTerminalState(t *testing.T) {
+	h := Handler{
+		bindings:   make(map[string]*BindingOperation),
+		bindingsMu: &sync.RWMutex{},
+	}
+
+	op := &BindingOperation{state: BindingInProgress}
+	h.setBindingOp("binding-1", op)
+	op.succeed(map[string]interface{}{"user": "u"})
+
+	for i := 0; i < 2; i++ {
+		lo, err := h.GetLastBindingOperation("instance-1", "binding-1", brokerapi.PollDetails{})
+		if err != nil {
+			t.Fatalf("poll %d: unexpected error: %s", i, err)
+		}
+		if lo.State != brokerapi.Succeeded {
+			t.Fatalf("poll %d: expected state %q, got %q", i, brokerapi.Succeeded, lo.State)
+		}
+	}
+}
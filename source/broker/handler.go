@@ -1,37 +1,81 @@
 package broker
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/nu7hatch/gouuid"
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
 
 	"github.com/s-matyukevich/bosh-broker/source/bosh"
 	"github.com/s-matyukevich/bosh-broker/source/config"
+	"github.com/s-matyukevich/bosh-broker/source/credhub"
+	"github.com/s-matyukevich/bosh-broker/source/store"
 	"github.com/s-matyukevich/bosh-broker/source/tmpl"
 )
 
-func NewHandler(config *config.Config) (Handler, error) {
+// defaultBindTimeout applies to any plan that leaves BindTimeout empty. A
+// zero timeout would let a hung bind/unbind script hold that instance's
+// lock forever, since instanceLocks stays held for the async script's
+// entire run (see the comment on bind) - wedging every later Deprovision,
+// Update, LastOperation or Bind/Unbind against that same instanceID.
+const defaultBindTimeout = 15 * time.Minute
+
+func NewHandler(config *config.Config, logger zerolog.Logger) (Handler, error) {
 	h := Handler{}
 	h.config = config
+	h.logger = logger
 	h.templates = make(map[string]*Templates, 0)
-	h.instances = make(map[string]*ServiceInstance, 0)
+	h.bindings = make(map[string]*BindingOperation, 0)
+	h.bindingsMu = &sync.RWMutex{}
+	h.instanceLocks = newKeyedMutex()
 	var err error
+	// State (instances/bindings) lives in the BoltDB store rather than an
+	// in-memory map, so it survives a broker restart: every read goes
+	// straight through to disk, which doubles as the rehydration step.
+	h.store, err = store.NewBoltStore(config.StorePath)
+	if err != nil {
+		return h, err
+	}
 	h.bosh, h.boshUUID, err = bosh.NewBoshProxy(config.BoshTarget, config.BoshUser, config.BoshPassword)
 	if err != nil {
 		return h, err
 	}
-	for key, p := range config.Plans {
-		t := &Templates{}
-		t.ManifestTmpl, err = prepareTemplate(p.ManifestTemplate)
+	if config.Credhub.Enabled {
+		h.credhub, err = credhub.NewClient(config.Credhub.URL, config.Credhub.UAAURL, config.Credhub.Client, config.Credhub.ClientSecret, config.Credhub.CACertPath, config.Credhub.ClientCertPath, config.Credhub.ClientKeyPath)
 		if err != nil {
 			return h, err
 		}
+	}
+	for key, p := range config.Plans {
+		t := &Templates{}
+		switch p.Engine {
+		case "", "gotemplate":
+			var manifestTmpl *tmpl.Template
+			manifestTmpl, err = prepareTemplate(p.ManifestTemplate)
+			if err != nil {
+				return h, err
+			}
+			t.Engine = &tmpl.GoTemplateEngine{Manifest: manifestTmpl}
+		case "bosh-ops":
+			t.Engine = &tmpl.BoshOpsEngine{
+				BaseManifest: filepath.Join("templates", p.ManifestTemplate),
+				OpsFiles:     templatePaths(p.OpsFiles),
+				VarsFiles:    templatePaths(p.VarsFiles),
+			}
+		default:
+			return h, fmt.Errorf("unknown render engine %q for plan %s", p.Engine, key)
+		}
 		t.BindTmpl, err = prepareTemplate(p.BindTemplate)
 		if err != nil {
 			return h, err
@@ -48,11 +92,53 @@ func NewHandler(config *config.Config) (Handler, error) {
 		if err != nil {
 			return h, err
 		}
+		if p.BindTimeout != "" {
+			t.BindTimeout, err = time.ParseDuration(p.BindTimeout)
+			if err != nil {
+				return h, err
+			}
+		} else {
+			t.BindTimeout = defaultBindTimeout
+		}
 		h.templates[key] = t
 	}
+	if config.MetricsAddr != "" {
+		go h.serveMetrics(config.MetricsAddr)
+	}
+	go h.sweepBindingOps()
 	return h, nil
 }
 
+// sweepBindingOps periodically reclaims terminal BindingOperations that
+// have sat in h.bindings for longer than bindingOperationTTL, bounding the
+// map's growth without evicting an operation the instant it's first
+// reported (see the comment on bindingOperationTTL). It runs for the
+// lifetime of the broker.
+func (h Handler) sweepBindingOps() {
+	ticker := time.NewTicker(bindingOperationTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.bindingsMu.Lock()
+		for bindingID, op := range h.bindings {
+			if op.staleSince(bindingOperationTTL) {
+				delete(h.bindings, bindingID)
+			}
+		}
+		h.bindingsMu.Unlock()
+	}
+}
+
+// serveMetrics exposes the Prometheus /metrics endpoint. It runs in its own
+// goroutine for the lifetime of the broker, so a failure here is logged
+// rather than returned from NewHandler.
+func (h Handler) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		h.logger.Error().Err(err).Str("addr", addr).Msg("metrics server stopped")
+	}
+}
+
 func prepareTemplate(path string) (*tmpl.Template, error) {
 	if path == "" {
 		return nil, nil
@@ -64,12 +150,104 @@ func prepareTemplate(path string) (*tmpl.Template, error) {
 	return tmpl.NewTemplate(string(str))
 }
 
+func templatePaths(names []string) []string {
+	paths := make([]string, len(names))
+	for i, n := range names {
+		paths[i] = filepath.Join("templates", n)
+	}
+	return paths
+}
+
 type Handler struct {
 	config    *config.Config
+	// logger is zerolog.Logger, not a pointer: it's designed to be copied
+	// by value, unlike the mutexes below, so it's safe on a value-receiver
+	// Handler.
+	logger    zerolog.Logger
 	templates map[string]*Templates
-	instances map[string]*ServiceInstance
-	bosh      *bosh.BoshProxy
-	boshUUID  string
+	store    store.Store
+	bindings map[string]*BindingOperation
+	// bindingsMu guards bindings. Handler is passed around by value, so
+	// this has to be a pointer: embedding sync.RWMutex by value would
+	// give every copy of Handler its own independent lock.
+	bindingsMu *sync.RWMutex
+	// instanceLocks serializes Provision/Bind/Unbind/Update/Deprovision/
+	// LastOperation per instanceID so two requests for the same instance
+	// can't race on BOSH uploads or deployment file writes, while
+	// different instances still proceed in parallel.
+	instanceLocks *keyedMutex
+	bosh          bosh.Client
+	boshUUID      string
+	credhub       *credhub.Client
+}
+
+func (h Handler) getBindingOp(bindingID string) (*BindingOperation, bool) {
+	h.bindingsMu.RLock()
+	defer h.bindingsMu.RUnlock()
+	op, ok := h.bindings[bindingID]
+	return op, ok
+}
+
+func (h Handler) setBindingOp(bindingID string, op *BindingOperation) {
+	h.bindingsMu.Lock()
+	defer h.bindingsMu.Unlock()
+	h.bindings[bindingID] = op
+}
+
+// credhubPath is where a binding's credentials live in CredHub when
+// config.Credhub.Enabled, matching the CF secure service credentials
+// contract.
+func (h Handler) credhubPath(instanceID, bindingID string) string {
+	return fmt.Sprintf("/c/%s/%s/%s/credentials", h.config.BrokerId, instanceID, bindingID)
+}
+
+// storeCredentials returns the credentials that should actually be handed
+// back to CF: either creds unchanged, or, when CredHub is enabled, a
+// {"credhub-ref": path} pointer after creds have been written there.
+func (h Handler) storeCredentials(instanceID, bindingID string, creds map[string]interface{}) (map[string]interface{}, error) {
+	if !h.config.Credhub.Enabled {
+		return creds, nil
+	}
+	path := h.credhubPath(instanceID, bindingID)
+	if err := h.credhub.Put(path, creds); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"credhub-ref": path}, nil
+}
+
+// deleteBindingCredentials removes a binding's CredHub entry (if enabled)
+// and its store record.
+func (h Handler) deleteBindingCredentials(instanceID, bindingID string) error {
+	if h.config.Credhub.Enabled {
+		if err := h.credhub.Delete(h.credhubPath(instanceID, bindingID)); err != nil {
+			return err
+		}
+	}
+	return h.store.DeleteBinding(bindingID)
+}
+
+// loadInstance rebuilds a ServiceInstance for instanceID from the store,
+// reattaching the plan's config and parsed templates (which aren't
+// serializable and so aren't persisted themselves).
+func (h Handler) loadInstance(instanceID string) (*ServiceInstance, error) {
+	rec, err := h.store.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceInstance{
+		Config:         h.config.Plans[rec.PlanID],
+		Templates:      h.templates[rec.PlanID],
+		InstanceParams: rec.Params,
+		LastTaskId:     rec.LastTaskId,
+	}, nil
+}
+
+func (h Handler) saveInstance(instanceID, planID string, s *ServiceInstance) error {
+	return h.store.PutInstance(instanceID, &store.Instance{
+		PlanID:     planID,
+		Params:     s.InstanceParams,
+		LastTaskId: s.LastTaskId,
+	})
 }
 
 func (h Handler) Services() []brokerapi.Service {
@@ -81,88 +259,252 @@ func (h Handler) Services() []brokerapi.Service {
 		PlanUpdatable: false,
 	}
 	for key, p := range h.config.Plans {
-		service.Plans = append(service.Plans, brokerapi.ServicePlan{
+		plan := brokerapi.ServicePlan{
 			ID:          key,
 			Name:        p.Name,
 			Description: p.Description,
-		})
+		}
+		if p.Schemas != nil {
+			plan.Schemas = &brokerapi.ServiceSchemas{
+				Instance: brokerapi.ServiceInstanceSchema{
+					Create: brokerapi.Schema{Parameters: p.Schemas.ServiceInstance.Create},
+					Update: brokerapi.Schema{Parameters: p.Schemas.ServiceInstance.Update},
+				},
+				Binding: brokerapi.ServiceBindingSchema{
+					Create: brokerapi.Schema{Parameters: p.Schemas.ServiceBinding.Create},
+				},
+			}
+		}
+		service.Plans = append(service.Plans, plan)
 	}
 	return []brokerapi.Service{service}
 }
 
-func (h Handler) Provision(instanceID string, details brokerapi.ProvisionDetails, _ bool) (brokerapi.ProvisionedServiceSpec, error) {
+func (h Handler) Provision(instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
+	const opName = "provision"
+	inflightOperations.WithLabelValues(opName).Inc()
+	defer inflightOperations.WithLabelValues(opName).Dec()
+
+	start := time.Now()
+	spec, taskID, err := h.provision(instanceID, details, asyncAllowed)
+	h.trackOperation(opName, instanceID, "", details.PlanID, taskID, start, err)
+	return spec, err
+}
+
+func (h Handler) provision(instanceID string, details brokerapi.ProvisionDetails, _ bool) (brokerapi.ProvisionedServiceSpec, string, error) {
+	defer h.instanceLocks.Lock(instanceID)()
 	s := brokerapi.ProvisionedServiceSpec{
 		IsAsync:      true,
 		DashboardURL: "",
 	}
 	config := h.config.Plans[details.PlanID]
 	templates := h.templates[details.PlanID]
+	if config.Schemas != nil {
+		if err := validateAgainstSchema(config.Schemas.ServiceInstance.Create, details.RawParameters); err != nil {
+			return s, "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "provision-validation")
+		}
+	}
 	params := make(map[string]interface{}, 0)
 	if details.RawParameters != nil {
 		err := json.Unmarshal(details.RawParameters, &params)
 		if err != nil {
-			return s, err
+			return s, "", err
 		}
 	}
 	service := &ServiceInstance{config, templates, params, ""}
 	var err error
 	service.LastTaskId, err = h.doDeployment(instanceID, service)
 	if err != nil {
-		return s, err
+		return s, "", err
 	}
-	h.instances[instanceID] = service
-	return s, err
+	err = h.saveInstance(instanceID, details.PlanID, service)
+	return s, service.LastTaskId, err
 }
 
-func (h Handler) Deprovision(instanceID string, _ brokerapi.DeprovisionDetails, _ bool) (brokerapi.IsAsync, error) {
+func (h Handler) Deprovision(instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.IsAsync, error) {
+	const opName = "deprovision"
+	inflightOperations.WithLabelValues(opName).Inc()
+	defer inflightOperations.WithLabelValues(opName).Dec()
+
+	start := time.Now()
+	async, taskID, err := h.deprovision(instanceID, details, asyncAllowed)
+	h.trackOperation(opName, instanceID, "", details.PlanID, taskID, start, err)
+	return async, err
+}
+
+func (h Handler) deprovision(instanceID string, _ brokerapi.DeprovisionDetails, _ bool) (brokerapi.IsAsync, string, error) {
+	defer h.instanceLocks.Lock(instanceID)()
 	deploymentPath := fmt.Sprintf("deployments/%s/", instanceID)
 	err := os.RemoveAll(deploymentPath)
 	if err != nil {
-		return true, err
+		return true, "", err
+	}
+	if h.config.Credhub.Enabled {
+		if err := h.credhub.DeleteByPath(fmt.Sprintf("/c/%s/%s/", h.config.BrokerId, instanceID)); err != nil {
+			return true, "", err
+		}
+	}
+	rec, err := h.store.GetInstance(instanceID)
+	if err != nil {
+		return true, "", err
+	}
+	rec.LastTaskId, err = h.bosh.DeleteDeployment("deployment" + instanceID)
+	if err != nil {
+		return true, "", err
 	}
-	service := h.instances[instanceID]
-	service.LastTaskId, err = h.bosh.DeleteDeployment("deployment" + instanceID)
-	return true, err
+	// Don't delete the record yet: DeleteDeployment only returns a task ID,
+	// the deletion itself is still running on the director. Keep a
+	// tombstone so LastOperation can keep polling LastTaskId and only
+	// remove the record once that task reaches a terminal state.
+	rec.Deleting = true
+	return true, rec.LastTaskId, h.store.PutInstance(instanceID, rec)
+}
+
+// Bind's apiVersion is the caller's X-Broker-API-Version header value.
+// Binding-level async (and the GetBinding/GetLastBindingOperation polling
+// it implies) is only part of the OSB API from 2.14 onward, so a platform
+// on an older version gets the synchronous behavior regardless of
+// asyncAllowed/accepts_incomplete.
+func (h Handler) Bind(instanceID, bindingID string, details brokerapi.BindDetails, asyncAllowed bool, apiVersion string) (brokerapi.Binding, error) {
+	const opName = "bind"
+	inflightOperations.WithLabelValues(opName).Inc()
+	defer inflightOperations.WithLabelValues(opName).Dec()
+
+	start := time.Now()
+	b, err := h.bind(instanceID, bindingID, details, asyncAllowed && supportsAsyncBinding(apiVersion))
+	h.trackOperation(opName, instanceID, bindingID, details.PlanID, "", start, err)
+	return b, err
 }
 
-func (h Handler) Bind(instanceID, bindingID string, details brokerapi.BindDetails) (brokerapi.Binding, error) {
-	service := h.instances[instanceID]
+func (h Handler) bind(instanceID, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (brokerapi.Binding, error) {
+	// unlock is released synchronously below for the sync path, but handed
+	// off to runBindAsync for the async path: the instance must stay locked
+	// until the bind script actually finishes, not just until this call
+	// returns, or a concurrent Deprovision could rip out the deployment
+	// directory out from under the still-running script.
+	unlock := h.instanceLocks.Lock(instanceID)
+	service, err := h.loadInstance(instanceID)
+	if err != nil {
+		unlock()
+		return brokerapi.Binding{}, err
+	}
+	if service.Config.Schemas != nil {
+		if err := validateAgainstSchema(service.Config.Schemas.ServiceBinding.Create, details.RawParameters); err != nil {
+			unlock()
+			return brokerapi.Binding{}, brokerapi.NewFailureResponse(err, http.StatusBadRequest, "bind-validation")
+		}
+	}
 	b := brokerapi.Binding{}
 	bindPath := fmt.Sprintf("deployments/%s/%s_bind.sh", instanceID, bindingID)
-	err := service.Templates.BindTmpl.ExecuteAndSave(service.InstanceParams, bindPath, 0777)
-	if err != nil {
+	if err := service.Templates.BindTmpl.ExecuteAndSave(service.InstanceParams, bindPath, 0777); err != nil {
+		unlock()
 		return b, err
 	}
-	cmd := exec.Command(bindPath)
-	out, err := cmd.Output()
-	if err != nil {
+
+	if !asyncAllowed {
+		defer unlock()
+		cmd := exec.Command(bindPath)
+		out, err := runCapturingStderr(cmd, h.logger)
+		if err != nil {
+			return b, err
+		}
+		creds := make(map[string]interface{}, 0)
+		if err := json.Unmarshal(out, &creds); err != nil {
+			return b, err
+		}
+		creds, err = h.storeCredentials(instanceID, bindingID, creds)
+		if err != nil {
+			return b, err
+		}
+		b.Credentials = creds
+		err = h.store.PutBinding(bindingID, &store.Binding{InstanceID: instanceID, Credentials: creds})
 		return b, err
 	}
-	b.Credentials = make(map[string]interface{}, 0)
-	err = json.Unmarshal(out, &b.Credentials)
-	return b, err
+
+	op := &BindingOperation{state: BindingInProgress}
+	h.setBindingOp(bindingID, op)
+	go func() {
+		defer unlock()
+		h.runBindAsync(instanceID, bindingID, bindPath, service.Templates.BindTimeout, op)
+	}()
+	b.IsAsync = true
+	b.OperationData = bindingID
+	return b, nil
 }
 
-func (h Handler) Unbind(instanceID, bindingID string, _ brokerapi.UnbindDetails) error {
-	service := h.instances[instanceID]
-	if service.Templates.UnbindTmpl != nil {
-		unbindPath := fmt.Sprintf("deployments/%s/%s_unbind.sh", instanceID, bindingID)
-		err := service.Templates.UnbindTmpl.ExecuteAndSave(service.InstanceParams, unbindPath, 0777)
-		if err != nil {
-			return err
-		}
+// Unbind's apiVersion is the caller's X-Broker-API-Version header value;
+// see the matching comment on Bind.
+func (h Handler) Unbind(instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool, apiVersion string) (brokerapi.UnbindSpec, error) {
+	const opName = "unbind"
+	inflightOperations.WithLabelValues(opName).Inc()
+	defer inflightOperations.WithLabelValues(opName).Dec()
+
+	start := time.Now()
+	spec, err := h.unbind(instanceID, bindingID, details, asyncAllowed && supportsAsyncBinding(apiVersion))
+	h.trackOperation(opName, instanceID, bindingID, details.PlanID, "", start, err)
+	return spec, err
+}
+
+func (h Handler) unbind(instanceID, bindingID string, _ brokerapi.UnbindDetails, asyncAllowed bool) (brokerapi.UnbindSpec, error) {
+	// See the matching comment in bind: unlock must outlive this call for
+	// the async path, so it's handed off to runUnbindAsync rather than
+	// deferred here.
+	unlock := h.instanceLocks.Lock(instanceID)
+	service, err := h.loadInstance(instanceID)
+	if err != nil {
+		unlock()
+		return brokerapi.UnbindSpec{}, err
+	}
+	if service.Templates.UnbindTmpl == nil {
+		defer unlock()
+		return brokerapi.UnbindSpec{}, h.deleteBindingCredentials(instanceID, bindingID)
+	}
+
+	unbindPath := fmt.Sprintf("deployments/%s/%s_unbind.sh", instanceID, bindingID)
+	if err := service.Templates.UnbindTmpl.ExecuteAndSave(service.InstanceParams, unbindPath, 0777); err != nil {
+		unlock()
+		return brokerapi.UnbindSpec{}, err
+	}
+
+	if !asyncAllowed {
+		defer unlock()
 		cmd := exec.Command(unbindPath)
-		return cmd.Run()
+		if _, err := runCapturingStderr(cmd, h.logger); err != nil {
+			return brokerapi.UnbindSpec{}, err
+		}
+		return brokerapi.UnbindSpec{}, h.deleteBindingCredentials(instanceID, bindingID)
 	}
-	return nil
+
+	op := &BindingOperation{state: BindingInProgress}
+	h.setBindingOp(bindingID, op)
+	go func() {
+		defer unlock()
+		h.runUnbindAsync(instanceID, bindingID, unbindPath, service.Templates.BindTimeout, op)
+	}()
+	return brokerapi.UnbindSpec{IsAsync: true, OperationData: bindingID}, nil
 }
 
 func (h Handler) LastOperation(instanceID string) (brokerapi.LastOperation, error) {
+	const opName = "last_operation"
+	inflightOperations.WithLabelValues(opName).Inc()
+	defer inflightOperations.WithLabelValues(opName).Dec()
+
+	start := time.Now()
+	op, taskID, err := h.lastOperation(instanceID)
+	h.trackOperation(opName, instanceID, "", "", taskID, start, err)
+	return op, err
+}
+
+func (h Handler) lastOperation(instanceID string) (brokerapi.LastOperation, string, error) {
+	defer h.instanceLocks.Lock(instanceID)()
 	op := brokerapi.LastOperation{}
-	service := h.instances[instanceID]
-	status, err := h.bosh.Status(service.LastTaskId)
+	rec, err := h.store.GetInstance(instanceID)
+	if err != nil {
+		return op, "", err
+	}
+	status, err := h.bosh.Status(rec.LastTaskId)
 	if err != nil {
-		return op, err
+		return op, rec.LastTaskId, err
 	}
 	switch status {
 	case "queued", "processing":
@@ -175,14 +517,41 @@ func (h Handler) LastOperation(instanceID string) (brokerapi.LastOperation, erro
 		err = fmt.Errorf("unknown tasks status: %s", status)
 	}
 
-	return op, err
+	if rec.Deleting && (op.State == brokerapi.Succeeded || op.State == brokerapi.Failed) {
+		// The delete-deployment task has reached a terminal state: the
+		// tombstone kept around since deprovision can now be removed.
+		return op, rec.LastTaskId, h.store.DeleteInstance(instanceID)
+	}
+	return op, rec.LastTaskId, err
 }
 
-func (h Handler) Update(instanceID string, details brokerapi.UpdateDetails, _ bool) (brokerapi.IsAsync, error) {
-	service := h.instances[instanceID]
-	var err error
+func (h Handler) Update(instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.IsAsync, error) {
+	const opName = "update"
+	inflightOperations.WithLabelValues(opName).Inc()
+	defer inflightOperations.WithLabelValues(opName).Dec()
+
+	start := time.Now()
+	async, taskID, err := h.update(instanceID, details, asyncAllowed)
+	h.trackOperation(opName, instanceID, "", details.PlanID, taskID, start, err)
+	return async, err
+}
+
+func (h Handler) update(instanceID string, details brokerapi.UpdateDetails, _ bool) (brokerapi.IsAsync, string, error) {
+	defer h.instanceLocks.Lock(instanceID)()
+	service, err := h.loadInstance(instanceID)
+	if err != nil {
+		return true, "", err
+	}
+	if service.Config.Schemas != nil {
+		if err := validateAgainstSchema(service.Config.Schemas.ServiceInstance.Update, details.RawParameters); err != nil {
+			return true, "", brokerapi.NewFailureResponse(err, http.StatusBadRequest, "update-validation")
+		}
+	}
 	service.LastTaskId, err = h.doDeployment(instanceID, service)
-	return true, err
+	if err != nil {
+		return true, service.LastTaskId, err
+	}
+	return true, service.LastTaskId, h.saveInstance(instanceID, details.PlanID, service)
 }
 
 func (h Handler) doDeployment(instanceID string, s *ServiceInstance) (string, error) {
@@ -191,7 +560,7 @@ func (h Handler) doDeployment(instanceID string, s *ServiceInstance) (string, er
 		return "", err
 	}
 	deploymentPath := fmt.Sprintf("deployments/%s/manifest.yml", instanceID)
-	err = s.Templates.ManifestTmpl.ExecuteAndSave(s.InstanceParams, deploymentPath, 0660)
+	err = s.Templates.Engine.Render(s.InstanceParams, deploymentPath)
 	if err != nil {
 		return "", err
 	}
@@ -214,13 +583,83 @@ func (h Handler) doDeployment(instanceID string, s *ServiceInstance) (string, er
 	return h.bosh.Deploy(deploymentPath)
 }
 
+// trackOperation records a completed broker operation: it updates the
+// Prometheus counters/histogram and emits a structured log line, so every
+// Provision/Bind/Unbind/Deprovision/Update/LastOperation goes through the
+// same observability path regardless of outcome.
+func (h Handler) trackOperation(op, instanceID, bindingID, planID, taskID string, start time.Time, err error) {
+	duration := time.Since(start)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	operationsTotal.WithLabelValues(op, planID, result).Inc()
+	operationDuration.WithLabelValues(op, planID).Observe(duration.Seconds())
+
+	event := h.logger.Info()
+	if err != nil {
+		event = h.logger.Error()
+	}
+	event.
+		Str("op", op).
+		Str("instance_id", instanceID).
+		Str("binding_id", bindingID).
+		Str("plan_id", planID).
+		Str("task_id", taskID).
+		Int64("duration_ms", duration.Milliseconds()).
+		AnErr("err", err).
+		Msg("broker operation completed")
+}
+
+// runCapturingStderr runs cmd to completion, relaying each line it writes
+// to stderr as a WARN log instead of letting it vanish, and returns what it
+// wrote to stdout.
+func runCapturingStderr(cmd *exec.Cmd, logger zerolog.Logger) ([]byte, error) {
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Warn().Str("cmd", cmd.Path).Msg(scanner.Text())
+		}
+		close(done)
+	}()
+
+	out, readErr := ioutil.ReadAll(stdout)
+	<-done
+	if err := cmd.Wait(); err != nil {
+		return out, err
+	}
+	return out, readErr
+}
+
 func (h Handler) prepareParams(instanceID string, params map[string]interface{}, plan *config.ServicePlan) error {
 	for _, p := range plan.Params {
-		if _, ok := params[p.Name]; ok {
+		if v, ok := params[p.Name]; ok {
+			coerced, err := coerceParam(v, p.Type)
+			if err != nil {
+				return fmt.Errorf("parameter %s: %s", p.Name, err)
+			}
+			params[p.Name] = coerced
 			continue
 		}
 		if p.Default != nil {
-			params[p.Name] = p.Default
+			coerced, err := coerceParam(p.Default, p.Type)
+			if err != nil {
+				return fmt.Errorf("parameter %s default: %s", p.Name, err)
+			}
+			params[p.Name] = coerced
 		} else if p.Random {
 			u, err := uuid.NewV4()
 			if err != nil {
@@ -0,0 +1,32 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/s-matyukevich/bosh-broker/source/config"
+	"github.com/s-matyukevich/bosh-broker/source/tmpl"
+)
+
+// Templates holds the parsed templates used to provision, bind and unbind a
+// service instance of a given plan.
+type Templates struct {
+	// Engine renders the deployment manifest; which implementation backs
+	// it is selected per-plan via config.ServicePlan.Engine.
+	Engine       tmpl.RenderEngine
+	BindTmpl     *tmpl.Template
+	UnbindTmpl   *tmpl.Template
+	StemcellTmpl *tmpl.Template
+	ReleaseTmpl  *tmpl.Template
+	// BindTimeout bounds how long an async bind/unbind script is allowed
+	// to run before it's killed and reported as a failed operation.
+	BindTimeout time.Duration
+}
+
+// ServiceInstance tracks everything needed to redeploy or operate on a
+// single provisioned service instance.
+type ServiceInstance struct {
+	Config         *config.ServicePlan
+	Templates      *Templates
+	InstanceParams map[string]interface{}
+	LastTaskId     string
+}
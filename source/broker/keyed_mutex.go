@@ -0,0 +1,47 @@
+package broker
+
+import "sync"
+
+// keyedMutex hands out a lock per key, so callers operating on the same key
+// (e.g. the same instanceID) serialize while callers on different keys
+// proceed in parallel. Entries are refcounted and removed once nobody
+// holds them, so the map doesn't grow unbounded over the broker's life.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mu  sync.Mutex
+	ref int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedMutexEntry)}
+}
+
+// Lock blocks until key is free, then returns an unlock function the
+// caller must call (typically via defer) to release it.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	entry, ok := k.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		k.locks[key] = entry
+	}
+	entry.ref++
+	k.mu.Unlock()
+
+	entry.mu.Lock()
+
+	return func() {
+		entry.mu.Unlock()
+
+		k.mu.Lock()
+		entry.ref--
+		if entry.ref == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedMutexSerializesSameKey(t *testing.T) {
+	km := newKeyedMutex()
+	var active int32
+	var maxActive int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := km.Lock("instance-1")
+			defer unlock()
+
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			time.Sleep(time.Millisecond)
+			active--
+		}()
+	}
+	wg.Wait()
+
+	if maxActive != 1 {
+		t.Fatalf("expected at most 1 concurrent holder of the same key, saw %d", maxActive)
+	}
+}
+
+func TestKeyedMutexAllowsDifferentKeysInParallel(t *testing.T) {
+	km := newKeyedMutex()
+	start := make(chan struct{})
+	locked := make(chan struct{}, 2)
+
+	// Each goroutine keeps its key locked until start is closed, so both
+	// can only report in if the two locks are held at the same time.
+	for _, key := range []string{"instance-1", "instance-2"} {
+		key := key
+		go func() {
+			unlock := km.Lock(key)
+			defer unlock()
+			locked <- struct{}{}
+			<-start
+		}()
+	}
+
+	defer close(start)
+	timeout := time.After(time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-locked:
+		case <-timeout:
+			t.Fatal("locks on different keys should not block each other")
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package broker
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	operationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_operations_total",
+		Help: "Total broker operations by op, plan and result.",
+	}, []string{"op", "plan", "result"})
+
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "broker_operation_duration_seconds",
+		Help: "Broker operation latency by op and plan.",
+	}, []string{"op", "plan"})
+
+	inflightOperations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "broker_inflight_operations",
+		Help: "Broker operations currently in flight, by op.",
+	}, []string{"op"})
+)
+
+func init() {
+	prometheus.MustRegister(operationsTotal, operationDuration, inflightOperations)
+}
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/rs/zerolog"
+
+	"github.com/s-matyukevich/bosh-broker/source/config"
+	"github.com/s-matyukevich/bosh-broker/source/store"
+	"github.com/s-matyukevich/bosh-broker/source/tmpl"
+)
+
+// fakeBoshClient is a bosh.Client that never shells out; it just counts
+// uploads and flags it if two ever overlap, which would mean Handler failed
+// to serialize concurrent Provisions for the same instance.
+type fakeBoshClient struct {
+	inFlight    int32
+	overlapped  int32
+	uploadCount int32
+}
+
+func (f *fakeBoshClient) UploadStemcell(stemcell string) error { return f.upload() }
+func (f *fakeBoshClient) UploadRelease(release string) error   { return f.upload() }
+
+func (f *fakeBoshClient) upload() error {
+	atomic.AddInt32(&f.uploadCount, 1)
+	if atomic.AddInt32(&f.inFlight, 1) > 1 {
+		atomic.StoreInt32(&f.overlapped, 1)
+	}
+	defer atomic.AddInt32(&f.inFlight, -1)
+	return nil
+}
+
+func (f *fakeBoshClient) Deploy(manifestPath string) (string, error)   { return "task-1", nil }
+func (f *fakeBoshClient) DeleteDeployment(name string) (string, error) { return "task-1", nil }
+func (f *fakeBoshClient) Status(taskId string) (string, error)         { return "done", nil }
+
+// TestProvisionSerializesConcurrentRequestsForSameInstance fires many
+// concurrent Provisions at the same instanceID under the race detector and
+// checks instanceLocks actually prevents them from racing on the BOSH
+// uploads, leaving the store in a consistent final state.
+func TestProvisionSerializesConcurrentRequestsForSameInstance(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	st, err := store.NewBoltStore(filepath.Join(dir, "broker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	manifestTmpl, err := tmpl.NewTemplate("name: {{.instance_id}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyTmpl, err := tmpl.NewTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeBoshClient{}
+	h := Handler{
+		config: &config.Config{Plans: map[string]*config.ServicePlan{"plan-1": {}}},
+		logger: zerolog.Nop(),
+		templates: map[string]*Templates{
+			"plan-1": {
+				Engine:       &tmpl.GoTemplateEngine{Manifest: manifestTmpl},
+				BindTmpl:     emptyTmpl,
+				ReleaseTmpl:  emptyTmpl,
+				StemcellTmpl: emptyTmpl,
+			},
+		},
+		store:         st,
+		bindings:      make(map[string]*BindingOperation),
+		bindingsMu:    &sync.RWMutex{},
+		instanceLocks: newKeyedMutex(),
+		bosh:          fake,
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Provision("instance-1", brokerapi.ProvisionDetails{PlanID: "plan-1"}, true)
+		}()
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&fake.overlapped) != 0 {
+		t.Fatal("concurrent Provisions for the same instance overlapped bosh uploads")
+	}
+	if got, want := atomic.LoadInt32(&fake.uploadCount), int32(2*n); got != want {
+		t.Fatalf("expected %d uploads (stemcell+release per call), got %d", want, got)
+	}
+
+	rec, err := st.GetInstance("instance-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.PlanID != "plan-1" {
+		t.Fatalf("expected stable store state for plan-1, got %q", rec.PlanID)
+	}
+}
@@ -0,0 +1,100 @@
+package broker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// validateAgainstSchema checks an OSB RawParameters payload against schema,
+// an inline JSON Schema document as configured on a plan. A nil/empty
+// schema means "anything goes", matching pre-schema behavior.
+func validateAgainstSchema(schema map[string]interface{}, raw []byte) error {
+	if len(schema) == 0 {
+		return nil
+	}
+	if len(raw) == 0 {
+		raw = []byte("{}")
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		msgs = append(msgs, e.String())
+	}
+	return fmt.Errorf("parameters do not match the plan's schema: %s", strings.Join(msgs, "; "))
+}
+
+// coerceParam converts value to the Go type implied by paramType ("",
+// "string", "int", "bool" or "object"), so a value supplied as a JSON
+// string/number still lands in the manifest templates with the type the
+// plan declared.
+func coerceParam(value interface{}, paramType string) (interface{}, error) {
+	switch paramType {
+	case "", "string":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprintf("%v", value), nil
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			return int(v), nil
+		case string:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("expected an int, got %q", v)
+			}
+			return n, nil
+		default:
+			return nil, fmt.Errorf("expected an int, got %T", v)
+		}
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("expected a bool, got %q", v)
+			}
+			return b, nil
+		default:
+			return nil, fmt.Errorf("expected a bool, got %T", v)
+		}
+	case "object":
+		if _, ok := value.(map[string]interface{}); ok {
+			return value, nil
+		}
+		// A Param.Default for a Type: "object" param comes from the plan's
+		// YAML config, where gopkg.in/yaml.v2 decodes nested mappings as
+		// map[interface{}]interface{} rather than map[string]interface{}.
+		// A Bind/Provision request's RawParameters, by contrast, is JSON
+		// and already unmarshals as map[string]interface{} above.
+		if m, ok := value.(map[interface{}]interface{}); ok {
+			obj := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				s, ok := k.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected an object, got a non-string key %v (%T)", k, k)
+				}
+				obj[s] = v
+			}
+			return obj, nil
+		}
+		return nil, fmt.Errorf("expected an object, got %T", value)
+	default:
+		return nil, fmt.Errorf("unknown parameter type %q", paramType)
+	}
+}
@@ -0,0 +1,50 @@
+package broker
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TestCoerceParamObjectAcceptsYAMLDecodedDefault covers a Type: "object"
+// Param.Default sourced from the plan's YAML config: yaml.v2 decodes
+// nested mappings as map[interface{}]interface{}, not
+// map[string]interface{}, so coerceParam must accept that shape too, not
+// just the map[string]interface{} a JSON-bodied Bind/Provision request
+// produces.
+func TestCoerceParamObjectAcceptsYAMLDecodedDefault(t *testing.T) {
+	var decoded interface{}
+	if err := yaml.Unmarshal([]byte("host: db.example.com\nport: 5432\n"), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.(map[interface{}]interface{}); !ok {
+		t.Fatalf("expected yaml.Unmarshal to decode a mapping as map[interface{}]interface{}, got %T", decoded)
+	}
+
+	coerced, err := coerceParam(decoded, "object")
+	if err != nil {
+		t.Fatalf("coerceParam rejected a YAML-decoded object default: %s", err)
+	}
+	want := map[string]interface{}{"host": "db.example.com", "port": 5432}
+	if !reflect.DeepEqual(coerced, want) {
+		t.Fatalf("expected %#v, got %#v", want, coerced)
+	}
+}
+
+func TestCoerceParamObjectAcceptsJSONDecodedValue(t *testing.T) {
+	value := map[string]interface{}{"host": "db.example.com"}
+	coerced, err := coerceParam(value, "object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(coerced, value) {
+		t.Fatalf("expected %#v, got %#v", value, coerced)
+	}
+}
+
+func TestCoerceParamObjectRejectsNonObject(t *testing.T) {
+	if _, err := coerceParam("not an object", "object"); err == nil {
+		t.Fatal("expected an error coercing a string to an object")
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+// Config holds the broker's top level configuration, typically loaded from
+// the broker's YAML config file.
+type Config struct {
+	BrokerId     string
+	BoshTarget   string
+	BoshUser     string
+	BoshPassword string
+	Plans        map[string]*ServicePlan
+	// StorePath is the path to the BoltDB file used to persist instances
+	// and bindings across broker restarts.
+	StorePath string
+	Credhub   CredhubConfig
+	// MetricsAddr is the address (e.g. ":9090") the Prometheus /metrics
+	// endpoint is served on. Empty disables it.
+	MetricsAddr string
+}
+
+// CredhubConfig configures storing binding credentials in CredHub instead
+// of returning them directly to CF.
+type CredhubConfig struct {
+	Enabled      bool
+	URL          string
+	UAAURL       string
+	Client       string
+	ClientSecret string
+	CACertPath   string
+	// ClientCertPath and ClientKeyPath, if both set, are presented to
+	// CredHub as a client certificate for mutual TLS. Leaving either empty
+	// falls back to server-only TLS (CredHub verifies nothing about us
+	// beyond the UAA token).
+	ClientCertPath string
+	ClientKeyPath  string
+}
+
+// ServicePlan describes a single offered plan: its catalog metadata and the
+// templates used to provision, bind and unbind it.
+type ServicePlan struct {
+	Name             string
+	Description      string
+	ManifestTemplate string
+	BindTemplate     string
+	UnbindTemplate   string
+	Stemcell         string
+	Release          string
+	Params           []Param
+	// Engine selects the RenderEngine used to produce this plan's
+	// manifest: "gotemplate" (the default) renders ManifestTemplate as a
+	// Go text/template; "bosh-ops" treats it as a base manifest composed
+	// with OpsFiles/VarsFiles via `bosh interpolate`.
+	Engine    string
+	OpsFiles  []string
+	VarsFiles []string
+	// BindTimeout bounds how long an async bind/unbind script may run
+	// before it is killed and reported as failed, e.g. "5m". Empty
+	// defaults to defaultBindTimeout: a hung script without a timeout
+	// would hold that instance's lock forever, wedging every other
+	// operation against it.
+	BindTimeout string
+	// Schemas holds the OSB API parameter JSON Schemas advertised for this
+	// plan and used to validate incoming requests. Nil means no schema is
+	// enforced.
+	Schemas *Schemas
+}
+
+// Schemas mirrors the OSB API "schemas" catalog object: inline JSON Schema
+// documents for the parameters accepted by provision, update and bind.
+type Schemas struct {
+	ServiceInstance InstanceSchemas
+	ServiceBinding  BindingSchemas
+}
+
+// InstanceSchemas holds the provision/update parameter schemas for a plan.
+type InstanceSchemas struct {
+	Create map[string]interface{}
+	Update map[string]interface{}
+}
+
+// BindingSchemas holds the bind parameter schema for a plan.
+type BindingSchemas struct {
+	Create map[string]interface{}
+}
+
+// Param describes a single deployment parameter, either supplied by the
+// user, defaulted, or generated at provision time.
+type Param struct {
+	Name     string
+	Default  interface{}
+	Random   bool
+	Optional bool
+	// Type is one of "string" (the default), "int", "bool" or "object".
+	// Supplied values and defaults are coerced to it before being handed
+	// to the manifest templates. A Random param's generated value is always
+	// a UUID string and is not coerced to Type.
+	Type string
+}
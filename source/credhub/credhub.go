@@ -0,0 +1,218 @@
+// Package credhub is a minimal client for storing and deleting broker
+// binding credentials in a CredHub server, authenticating against UAA with
+// the client_credentials grant. TLS is mutual when a client cert/key is
+// configured, and server-only verification otherwise.
+package credhub
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to a single CredHub server.
+type Client struct {
+	credhubURL   string
+	uaaURL       string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+
+	tokenMu     sync.Mutex
+	accessToken string
+	tokenExp    time.Time
+}
+
+// NewClient builds a Client. caCertPath, if non-empty, becomes the HTTP
+// client's only trusted root pool (the system roots are then not used) for
+// verifying CredHub/UAA's server certificate. clientCertPath/clientKeyPath, if both non-empty, are
+// presented to the server as a client certificate, upgrading the
+// connection to mutual TLS; otherwise only the server side is verified.
+func NewClient(credhubURL, uaaURL, clientID, clientSecret, caCertPath, clientCertPath, clientKeyPath string) (*Client, error) {
+	tlsConfig := &tls.Config{}
+	if caCertPath != "" {
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if clientCertPath != "" && clientKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &Client{
+		credhubURL:   strings.TrimRight(credhubURL, "/"),
+		uaaURL:       strings.TrimRight(uaaURL, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		httpClient:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// Put creates or overwrites a "json" type CredHub credential at path with
+// value.
+func (c *Client) Put(path string, value map[string]interface{}) error {
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"name":  path,
+		"type":  "json",
+		"value": value,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.credhubURL+"/api/v1/data", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("credhub put %s failed: %s", path, resp.Status)
+	}
+	return nil
+}
+
+// Delete removes a single credential by its exact path.
+func (c *Client) Delete(path string) error {
+	return c.delete("name", path)
+}
+
+// DeleteByPath removes every credential found under a path prefix, e.g.
+// "/c/<broker-guid>/<instance-id>/".
+func (c *Client) DeleteByPath(path string) error {
+	names, err := c.listByPath(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := c.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) listByPath(path string) ([]string, error) {
+	token, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("%s/api/v1/data?path=%s", c.credhubURL, url.QueryEscape(path))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("credhub list %s failed: %s", path, resp.Status)
+	}
+
+	var listing struct {
+		Credentials []struct {
+			Name string `json:"name"`
+		} `json:"credentials"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(listing.Credentials))
+	for i, cred := range listing.Credentials {
+		names[i] = cred.Name
+	}
+	return names, nil
+}
+
+func (c *Client) delete(key, value string) error {
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/api/v1/data?%s=%s", c.credhubURL, key, url.QueryEscape(value))
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("credhub delete %s failed: %s", value, resp.Status)
+	}
+	return nil
+}
+
+// token returns a cached UAA access token, fetching a new one via the
+// client_credentials grant once the current one is close to expiring.
+func (c *Client) token() (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.accessToken != "" && time.Now().Before(c.tokenExp) {
+		return c.accessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", c.clientID)
+	form.Set("client_secret", c.clientSecret)
+	form.Set("response_type", "token")
+
+	resp, err := c.httpClient.PostForm(c.uaaURL+"/oauth/token", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("uaa token request failed: %s", resp.Status)
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	c.accessToken = tok.AccessToken
+	c.tokenExp = time.Now().Add(time.Duration(tok.ExpiresIn-30) * time.Second)
+	return c.accessToken, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	instancesBucket = []byte("instances")
+	bindingsBucket  = []byte("bindings")
+)
+
+// BoltStore is a Store backed by a single BoltDB file. BoltDB only allows
+// one writer process at a time, which gives us the single-writer guarantee
+// this broker needs for free.
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB file at path and
+// ensures the instances/bindings buckets exist.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt store %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(instancesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bindingsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db}, nil
+}
+
+func (s *BoltStore) PutInstance(instanceID string, instance *Instance) error {
+	return s.put(instancesBucket, instanceID, instance)
+}
+
+func (s *BoltStore) GetInstance(instanceID string) (*Instance, error) {
+	instance := &Instance{}
+	if err := s.get(instancesBucket, instanceID, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (s *BoltStore) DeleteInstance(instanceID string) error {
+	return s.delete(instancesBucket, instanceID)
+}
+
+func (s *BoltStore) ListInstances() (map[string]*Instance, error) {
+	instances := make(map[string]*Instance, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(instancesBucket).ForEach(func(k, v []byte) error {
+			instance := &Instance{}
+			if err := json.Unmarshal(v, instance); err != nil {
+				return err
+			}
+			instances[string(k)] = instance
+			return nil
+		})
+	})
+	return instances, err
+}
+
+func (s *BoltStore) PutBinding(bindingID string, binding *Binding) error {
+	return s.put(bindingsBucket, bindingID, binding)
+}
+
+func (s *BoltStore) GetBinding(bindingID string) (*Binding, error) {
+	binding := &Binding{}
+	if err := s.get(bindingsBucket, bindingID, binding); err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+func (s *BoltStore) DeleteBinding(bindingID string) error {
+	return s.delete(bindingsBucket, bindingID)
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) put(bucket []byte, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStore) get(bucket []byte, key string, value interface{}) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("%s not found", key)
+		}
+		return json.Unmarshal(data, value)
+	})
+}
+
+func (s *BoltStore) delete(bucket []byte, key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
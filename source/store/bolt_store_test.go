@@ -0,0 +1,114 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "bolt-store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewBoltStore(filepath.Join(dir, "broker.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestInstanceRoundTrip(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	in := &Instance{PlanID: "plan-1", Params: map[string]interface{}{"size": "small"}, LastTaskId: "42"}
+	if err := s.PutInstance("instance-1", in); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetInstance("instance-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PlanID != in.PlanID || got.LastTaskId != in.LastTaskId {
+		t.Fatalf("got %+v, want %+v", got, in)
+	}
+
+	all, err := s.ListInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("expected 1 instance, got %d", len(all))
+	}
+
+	if err := s.DeleteInstance("instance-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetInstance("instance-1"); err == nil {
+		t.Fatal("expected error reading deleted instance")
+	}
+}
+
+func TestBindingRoundTrip(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	b := &Binding{InstanceID: "instance-1", Credentials: map[string]interface{}{"username": "admin"}}
+	if err := s.PutBinding("binding-1", b); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetBinding("binding-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.InstanceID != b.InstanceID {
+		t.Fatalf("got %+v, want %+v", got, b)
+	}
+
+	if err := s.DeleteBinding("binding-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBinding("binding-1"); err == nil {
+		t.Fatal("expected error reading deleted binding")
+	}
+}
+
+func TestNewBoltStoreRehydratesExistingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broker.db")
+
+	s1, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.PutInstance("instance-1", &Instance{PlanID: "plan-1", LastTaskId: "7"}); err != nil {
+		t.Fatal(err)
+	}
+	s1.Close()
+
+	s2, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	got, err := s2.GetInstance("instance-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastTaskId != "7" {
+		t.Fatalf("expected rehydrated LastTaskId 7, got %s", got.LastTaskId)
+	}
+}
@@ -0,0 +1,38 @@
+// Package store persists broker state (provisioned instances and bindings)
+// so a broker restart doesn't lose track of in-flight BOSH deployments.
+package store
+
+// Instance is the persisted view of a provisioned service instance: just
+// enough to rebuild a broker.ServiceInstance and keep polling its BOSH task.
+type Instance struct {
+	PlanID     string
+	Params     map[string]interface{}
+	LastTaskId string
+	// Deleting marks an instance whose deprovision was submitted to BOSH
+	// but whose delete-deployment task hasn't yet been observed to finish.
+	// The record is kept as a tombstone (rather than removed immediately)
+	// so LastOperation can keep polling LastTaskId until it reaches a
+	// terminal state, then delete the record itself.
+	Deleting bool
+}
+
+// Binding is the persisted view of a completed bind.
+type Binding struct {
+	InstanceID  string
+	Credentials map[string]interface{}
+}
+
+// Store is the persistence interface the broker uses in place of in-memory
+// maps, so instances and bindings survive a process restart.
+type Store interface {
+	PutInstance(instanceID string, instance *Instance) error
+	GetInstance(instanceID string) (*Instance, error)
+	DeleteInstance(instanceID string) error
+	ListInstances() (map[string]*Instance, error)
+
+	PutBinding(bindingID string, binding *Binding) error
+	GetBinding(bindingID string) (*Binding, error)
+	DeleteBinding(bindingID string) error
+
+	Close() error
+}
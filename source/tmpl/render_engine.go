@@ -0,0 +1,105 @@
+package tmpl
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RenderEngine produces a final deployment manifest for a service instance
+// from its params, writing the result to outputPath.
+type RenderEngine interface {
+	Render(params map[string]interface{}, outputPath string) error
+}
+
+// GoTemplateEngine renders a single Go text/template manifest. This is the
+// broker's original (and still default) rendering behavior.
+type GoTemplateEngine struct {
+	Manifest *Template
+}
+
+func (e *GoTemplateEngine) Render(params map[string]interface{}, outputPath string) error {
+	return e.Manifest.ExecuteAndSave(params, outputPath, 0660)
+}
+
+// BoshOpsEngine composes a base manifest with an ordered list of BOSH v2
+// ops-files and vars-files via `bosh interpolate`, so plans can reuse
+// upstream community manifests (cf-deployment, bosh-deployment) without
+// forking them into Go templates.
+type BoshOpsEngine struct {
+	BaseManifest string
+	OpsFiles     []string
+	VarsFiles    []string
+}
+
+func (e *BoshOpsEngine) Render(params map[string]interface{}, outputPath string) error {
+	args, varsFilePath, err := e.interpolateArgs(params)
+	if err != nil {
+		return err
+	}
+	if varsFilePath != "" {
+		defer os.Remove(varsFilePath)
+	}
+
+	cmd := exec.Command("bosh", args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outputPath, out, 0660)
+}
+
+// interpolateArgs builds the argv for `bosh interpolate`, along with the
+// vars-file it generated (empty if params was empty), split out of Render
+// so the composed args can be asserted on directly in tests without
+// actually shelling out to bosh.
+func (e *BoshOpsEngine) interpolateArgs(params map[string]interface{}) ([]string, string, error) {
+	args := []string{"interpolate", e.BaseManifest}
+	for _, opsFile := range e.OpsFiles {
+		args = append(args, "-o", opsFile)
+	}
+	for _, varsFile := range e.VarsFiles {
+		args = append(args, "-l", varsFile)
+	}
+
+	// Every param goes through a generated vars-file rather than -v: -v puts
+	// its value in the process's argv, visible to any other user on the box
+	// via ps/proc, and that's true of any plan param - not just the
+	// broker's own bosh_user/bosh_password - since a plan can mark any
+	// Param Random to generate its own secret. A vars-file also handles
+	// Type: "object" params, which fmt.Sprintf("%v", ...) can't.
+	if len(params) == 0 {
+		return args, "", nil
+	}
+	varsFilePath, err := writeVarsFile(params)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(args, "-l", varsFilePath), varsFilePath, nil
+}
+
+// writeVarsFile serializes vars as a BOSH vars-file (plain YAML mapping of
+// name to value) to a temp file the caller is responsible for removing.
+func writeVarsFile(vars map[string]interface{}) (string, error) {
+	data, err := yaml.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "bosh-ops-vars-*.yml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,66 @@
+package tmpl
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestBoshOpsEngineKeepsSecretAndObjectParamsOutOfArgv checks that a
+// secret param value (here bosh_password) and a Type: "object" param never
+// appear in the bosh interpolate argv, only in the generated vars-file.
+func TestBoshOpsEngineKeepsSecretAndObjectParamsOutOfArgv(t *testing.T) {
+	e := &BoshOpsEngine{BaseManifest: "manifest.yml"}
+	params := map[string]interface{}{
+		"bosh_password": "super-secret-value",
+		"config":        map[string]interface{}{"nested": "value"},
+	}
+
+	args, varsFilePath, err := e.interpolateArgs(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if varsFilePath == "" {
+		t.Fatal("expected a vars-file to be generated")
+	}
+	defer os.Remove(varsFilePath)
+
+	for _, arg := range args {
+		if strings.Contains(arg, "super-secret-value") {
+			t.Fatalf("secret param leaked into argv: %q", arg)
+		}
+		if strings.Contains(arg, "nested") {
+			t.Fatalf("object param leaked into argv: %q", arg)
+		}
+	}
+
+	data, err := ioutil.ReadFile(varsFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	if !strings.Contains(content, "super-secret-value") {
+		t.Fatal("expected secret param to be written to the vars-file")
+	}
+	if !strings.Contains(content, "nested") {
+		t.Fatal("expected object param to be written to the vars-file")
+	}
+}
+
+func TestBoshOpsEngineSkipsVarsFileWithNoParams(t *testing.T) {
+	e := &BoshOpsEngine{BaseManifest: "manifest.yml"}
+
+	args, varsFilePath, err := e.interpolateArgs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if varsFilePath != "" {
+		os.Remove(varsFilePath)
+		t.Fatal("expected no vars-file when there are no params")
+	}
+	want := []string{"interpolate", "manifest.yml"}
+	if len(args) != len(want) || args[0] != want[0] || args[1] != want[1] {
+		t.Fatalf("expected args %v, got %v", want, args)
+	}
+}
@@ -0,0 +1,42 @@
+package tmpl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// Template wraps a parsed text/template.Template so manifests, bind/unbind
+// scripts and release/stemcell versions can all be rendered the same way.
+type Template struct {
+	tmpl *template.Template
+}
+
+func NewTemplate(str string) (*Template, error) {
+	t, err := template.New("tmpl").Parse(str)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{t}, nil
+}
+
+func (t *Template) Execute(params map[string]interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := t.tmpl.Execute(buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (t *Template) ExecuteAndSave(params map[string]interface{}, path string, perm os.FileMode) error {
+	str, err := t.Execute(params)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(str), perm)
+}